@@ -0,0 +1,52 @@
+package actionrunner
+
+import (
+	"context"
+	"time"
+)
+
+// Stream identifies which of a container's output streams a LogSink line
+// came from.
+type Stream int
+
+const (
+	Stdout Stream = iota
+	Stderr
+)
+
+// LogSink receives a copy of everything RunRunner reads off the warden
+// process stream, in addition to whatever the in-process Emitter does with
+// it. Sinks exist so operators can capture container logs even when
+// loggregator is down, or for compliance archival.
+//
+// Write must not block the warden stream read loop; implementations are
+// expected to buffer internally and drop lines rather than stall it.
+type LogSink interface {
+	Write(ctx context.Context, stream Stream, ts time.Time, line []byte) error
+
+	// Flush emits whatever partial line is still buffered for stream, even
+	// though it never saw a trailing newline. Callers invoke it once a
+	// container's output stream has closed so a final unterminated line
+	// isn't lost.
+	Flush(ctx context.Context, stream Stream) error
+}
+
+type logFieldsKey struct{}
+
+// LogFields carries the identifiers sinks attach to each line as structured
+// data (e.g. the syslog sink's SD-PARAM fields). Fields left blank are
+// still emitted by the sinks, with an empty value.
+type LogFields struct {
+	ContainerHandle string
+	AppGUID         string
+	Index           string
+}
+
+func WithLogFields(ctx context.Context, fields LogFields) context.Context {
+	return context.WithValue(ctx, logFieldsKey{}, fields)
+}
+
+func LogFieldsFromContext(ctx context.Context) LogFields {
+	fields, _ := ctx.Value(logFieldsKey{}).(LogFields)
+	return fields
+}
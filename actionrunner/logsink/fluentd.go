@@ -0,0 +1,322 @@
+package logsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/executor/actionrunner"
+)
+
+const FluentdDroppedLineCount = "FluentdSinkDroppedLineCount"
+
+type fluentdLine struct {
+	stream actionrunner.Stream
+	ts     time.Time
+	line   []byte
+	fields actionrunner.LogFields
+}
+
+// FluentdSink forwards lines to a fluentd (or fluent-bit) instance using
+// the forward protocol's Message Mode: [tag, time, record, option].
+type FluentdSink struct {
+	address string
+	tag     string
+	ackMode bool
+	conn    net.Conn
+	reader  *bufio.Reader
+	metrics MetricsEmitter
+	queue   chan fluentdLine
+	done    chan struct{}
+	chunk   uint64
+	lines   *lineBuffer
+}
+
+func NewFluentdSink(address, tag string, ackMode bool, bufferSize int, metrics MetricsEmitter) (*FluentdSink, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FluentdSink{
+		address: address,
+		tag:     tag,
+		ackMode: ackMode,
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		metrics: metrics,
+		queue:   make(chan fluentdLine, bufferSize),
+		done:    make(chan struct{}),
+		lines:   newLineBuffer(),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *FluentdSink) Write(ctx context.Context, stream actionrunner.Stream, ts time.Time, line []byte) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.append(key, line) {
+		s.enqueue(stream, ts, l, fields)
+	}
+	return nil
+}
+
+// Flush emits whatever partial line is still buffered for stream, since the
+// container's output has closed without ever sending the trailing \n that
+// would otherwise complete it.
+func (s *FluentdSink) Flush(ctx context.Context, stream actionrunner.Stream) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.flush(key) {
+		s.enqueue(stream, time.Now(), l, fields)
+	}
+	return nil
+}
+
+func (s *FluentdSink) enqueue(stream actionrunner.Stream, ts time.Time, line []byte, fields actionrunner.LogFields) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case s.queue <- fluentdLine{stream: stream, ts: ts, line: buf, fields: fields}:
+	default:
+		if s.metrics != nil {
+			s.metrics.IncrementCounter(FluentdDroppedLineCount)
+		}
+	}
+}
+
+func (s *FluentdSink) Close() error {
+	close(s.done)
+	return s.conn.Close()
+}
+
+func (s *FluentdSink) loop() {
+	for {
+		select {
+		case l := <-s.queue:
+			s.send(l)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *FluentdSink) send(l fluentdLine) {
+	record := map[string]string{
+		"message":          string(l.line),
+		"stream":           streamName(l.stream),
+		"container_handle": l.fields.ContainerHandle,
+		"app_guid":         l.fields.AppGUID,
+		"index":            l.fields.Index,
+	}
+
+	var chunkID string
+	if s.ackMode {
+		s.chunk++
+		chunkID = encodeChunkID(s.chunk)
+	}
+
+	msg := encodeFluentdMessage(s.tag, l.ts.Unix(), record, chunkID)
+	if _, err := s.conn.Write(msg); err != nil {
+		if s.metrics != nil {
+			s.metrics.IncrementCounter(FluentdDroppedLineCount)
+		}
+		s.redial()
+		return
+	}
+
+	if s.ackMode {
+		s.awaitAck(chunkID)
+	}
+}
+
+// redial replaces a dead connection so a transient network error doesn't
+// permanently stop delivery.
+func (s *FluentdSink) redial() {
+	conn, err := net.Dial("tcp", s.address)
+	if err != nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+}
+
+// awaitAck reads the {"ack": "<chunk>"} msgpack map fluentd sends back in
+// ack mode and confirms it matches the chunk id this message was sent
+// with. A decode failure or a mismatched chunk id means the message may
+// not have been durably received downstream, so it counts as dropped
+// rather than being assumed delivered.
+func (s *FluentdSink) awaitAck(chunkID string) {
+	ackedChunk, err := decodeFluentdAck(s.reader)
+	if err != nil || ackedChunk != chunkID {
+		if s.metrics != nil {
+			s.metrics.IncrementCounter(FluentdDroppedLineCount)
+		}
+	}
+}
+
+func decodeFluentdAck(r *bufio.Reader) (string, error) {
+	n, err := readMsgpackMapHeader(r)
+	if err != nil {
+		return "", err
+	}
+
+	var ack string
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		value, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		if key == "ack" {
+			ack = value
+		}
+	}
+	return ack, nil
+}
+
+func readMsgpackMapHeader(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b&0xf0 == 0x80:
+		return int(b & 0x0f), nil
+	case b == 0xde:
+		hi, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		lo, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		return int(hi)<<8 | int(lo), nil
+	default:
+		return 0, fmt.Errorf("unexpected msgpack map header byte: 0x%x", b)
+	}
+}
+
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+
+	var n int
+	switch {
+	case b&0xe0 == 0xa0:
+		n = int(b & 0x1f)
+	case b == 0xd9:
+		nb, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(nb)
+	case b == 0xda:
+		hi, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		lo, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		n = int(hi)<<8 | int(lo)
+	default:
+		return "", fmt.Errorf("unexpected msgpack string header byte: 0x%x", b)
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+var _ actionrunner.LogSink = (*FluentdSink)(nil)
+
+func streamName(stream actionrunner.Stream) string {
+	if stream == actionrunner.Stderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+func encodeChunkID(n uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return string(b)
+}
+
+// encodeFluentdMessage packs [tag, time, record, option] as msgpack, per
+// the fluentd forward protocol's Message Mode.
+func encodeFluentdMessage(tag string, unixTime int64, record map[string]string, chunkID string) []byte {
+	var buf []byte
+	buf = appendFixArrayHeader(buf, 4)
+	buf = appendMsgpackString(buf, tag)
+	buf = appendMsgpackInt(buf, unixTime)
+	buf = appendMsgpackStringMap(buf, record)
+
+	if chunkID != "" {
+		buf = appendMsgpackFixMapHeader(buf, 1)
+		buf = appendMsgpackString(buf, "chunk")
+		buf = appendMsgpackString(buf, chunkID)
+	} else {
+		buf = appendMsgpackFixMapHeader(buf, 0)
+	}
+
+	return buf
+}
+
+func appendFixArrayHeader(buf []byte, n int) []byte {
+	return append(buf, 0x90|byte(n))
+}
+
+func appendMsgpackFixMapHeader(buf []byte, n int) []byte {
+	return append(buf, 0x80|byte(n))
+}
+
+func appendMsgpackStringMap(buf []byte, m map[string]string) []byte {
+	buf = appendMsgpackFixMapHeader(buf, len(m))
+	for k, v := range m {
+		buf = appendMsgpackString(buf, k)
+		buf = appendMsgpackString(buf, v)
+	}
+	return buf
+}
+
+func appendMsgpackString(buf []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= 0xff:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 0xda, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, s...)
+}
+
+func appendMsgpackInt(buf []byte, v int64) []byte {
+	b := make([]byte, 9)
+	b[0] = 0xd3
+	binary.BigEndian.PutUint64(b[1:], uint64(v))
+	return append(buf, b...)
+}
@@ -0,0 +1,145 @@
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-incubator/executor/actionrunner"
+)
+
+const DefaultJournaldSocketPath = "/run/systemd/journal/socket"
+
+const JournaldDroppedLineCount = "JournaldSinkDroppedLineCount"
+
+type journaldLine struct {
+	stream actionrunner.Stream
+	line   []byte
+	fields actionrunner.LogFields
+}
+
+// JournaldSink writes key=value records to the systemd-journald native
+// protocol socket.
+type JournaldSink struct {
+	socketPath string
+	conn       *net.UnixConn
+	metrics    MetricsEmitter
+	queue      chan journaldLine
+	done       chan struct{}
+	lines      *lineBuffer
+}
+
+func NewJournaldSink(socketPath string, bufferSize int, metrics MetricsEmitter) (*JournaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JournaldSink{
+		socketPath: socketPath,
+		conn:       conn,
+		metrics:    metrics,
+		queue:      make(chan journaldLine, bufferSize),
+		done:       make(chan struct{}),
+		lines:      newLineBuffer(),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *JournaldSink) Write(ctx context.Context, stream actionrunner.Stream, ts time.Time, line []byte) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.append(key, line) {
+		s.enqueue(stream, l, fields)
+	}
+	return nil
+}
+
+// Flush emits whatever partial line is still buffered for stream, since the
+// container's output has closed without ever sending the trailing \n that
+// would otherwise complete it.
+func (s *JournaldSink) Flush(ctx context.Context, stream actionrunner.Stream) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.flush(key) {
+		s.enqueue(stream, l, fields)
+	}
+	return nil
+}
+
+func (s *JournaldSink) enqueue(stream actionrunner.Stream, line []byte, fields actionrunner.LogFields) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case s.queue <- journaldLine{stream: stream, line: buf, fields: fields}:
+	default:
+		if s.metrics != nil {
+			s.metrics.IncrementCounter(JournaldDroppedLineCount)
+		}
+	}
+}
+
+func (s *JournaldSink) Close() error {
+	close(s.done)
+	return s.conn.Close()
+}
+
+func (s *JournaldSink) loop() {
+	for {
+		select {
+		case l := <-s.queue:
+			if _, err := s.conn.Write(encodeJournaldRecord(l)); err != nil {
+				if s.metrics != nil {
+					s.metrics.IncrementCounter(JournaldDroppedLineCount)
+				}
+				s.redial()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// redial replaces a dead socket connection so a transient error doesn't
+// permanently stop delivery.
+func (s *JournaldSink) redial() {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.socketPath, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = conn
+}
+
+var _ actionrunner.LogSink = (*JournaldSink)(nil)
+
+// encodeJournaldRecord builds a datagram of newline-separated KEY=value
+// fields per sd-journal's native protocol. Write has already split the raw
+// chunk on \n before it reaches here, but every value still goes through
+// sanitizeJournaldValue to guard against any newline a caller-supplied
+// field might carry.
+func encodeJournaldRecord(l journaldLine) []byte {
+	priority := "6"
+	if l.stream == actionrunner.Stderr {
+		priority = "3"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "PRIORITY=%s\n", priority)
+	fmt.Fprintf(&buf, "SYSLOG_IDENTIFIER=executor\n")
+	fmt.Fprintf(&buf, "CONTAINER_HANDLE=%s\n", sanitizeJournaldValue([]byte(l.fields.ContainerHandle)))
+	fmt.Fprintf(&buf, "APP_GUID=%s\n", sanitizeJournaldValue([]byte(l.fields.AppGUID)))
+	fmt.Fprintf(&buf, "INDEX=%s\n", sanitizeJournaldValue([]byte(l.fields.Index)))
+	fmt.Fprintf(&buf, "MESSAGE=%s\n", sanitizeJournaldValue(l.line))
+	return buf.Bytes()
+}
+
+func sanitizeJournaldValue(line []byte) []byte {
+	return bytes.ReplaceAll(line, []byte("\n"), []byte(" "))
+}
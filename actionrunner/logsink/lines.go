@@ -0,0 +1,62 @@
+package logsink
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/executor/actionrunner"
+)
+
+// lineBuffer reassembles complete lines out of raw warden chunks. Chunks
+// read off payload.GetData() don't align with line boundaries, so a line
+// split across two chunks (e.g. "hel" then "lo\n") has to be buffered and
+// joined here rather than framed as two truncated records. Buffering is
+// keyed per logical stream (one container's stdout, say) since a single
+// sink instance is shared across every container writing through it.
+type lineBuffer struct {
+	mu      sync.Mutex
+	partial map[string][]byte
+}
+
+func newLineBuffer() *lineBuffer {
+	return &lineBuffer{partial: map[string][]byte{}}
+}
+
+// append adds data under key and returns the complete lines it produced,
+// with their terminating \n stripped, retaining any trailing partial
+// segment under key for the next call.
+func (b *lineBuffer) append(key string, data []byte) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buf := append(b.partial[key], data...)
+	segments := bytes.Split(buf, []byte("\n"))
+	tail := segments[len(segments)-1]
+	lines := segments[:len(segments)-1]
+
+	if len(tail) > 0 {
+		b.partial[key] = append([]byte(nil), tail...)
+	} else {
+		delete(b.partial, key)
+	}
+	return lines
+}
+
+// flush returns and discards whatever partial segment is buffered under
+// key, for when a stream closes without a trailing newline.
+func (b *lineBuffer) flush(key string) [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tail, ok := b.partial[key]
+	if !ok {
+		return nil
+	}
+	delete(b.partial, key)
+	return [][]byte{tail}
+}
+
+func lineBufferKey(containerHandle string, stream actionrunner.Stream) string {
+	return fmt.Sprintf("%s|%d", containerHandle, stream)
+}
@@ -0,0 +1,165 @@
+// Package logsink provides LogSink implementations for actionrunner.RunRunner
+// that ship container stdout/stderr off-box to a syslog, journald, or
+// fluentd collector.
+package logsink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry-incubator/executor/actionrunner"
+)
+
+// MetricsEmitter is the minimal metrics surface logsink needs; it is
+// satisfied by metronClient in the newer parts of this codebase.
+type MetricsEmitter interface {
+	IncrementCounter(name string)
+}
+
+const SyslogDroppedLineCount = "SyslogSinkDroppedLineCount"
+
+const (
+	syslogFacilityLocal0 = 16
+	syslogSeverityInfo   = 6
+	syslogSeverityErr    = 3
+)
+
+type syslogLine struct {
+	stream actionrunner.Stream
+	ts     time.Time
+	line   []byte
+	fields actionrunner.LogFields
+}
+
+// SyslogSink writes RFC5424-formatted lines to a syslog server over TCP,
+// with container_handle/app_guid/index carried as structured data.
+type SyslogSink struct {
+	address string
+	appName string
+	conn    net.Conn
+	metrics MetricsEmitter
+	queue   chan syslogLine
+	done    chan struct{}
+	lines   *lineBuffer
+}
+
+// NewSyslogSink dials address (host:port) and starts the background worker
+// that drains the bufferSize-deep queue. Lines written once the queue is
+// full are dropped and counted via SyslogDroppedLineCount.
+func NewSyslogSink(address, appName string, bufferSize int, metrics MetricsEmitter) (*SyslogSink, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SyslogSink{
+		address: address,
+		appName: appName,
+		conn:    conn,
+		metrics: metrics,
+		queue:   make(chan syslogLine, bufferSize),
+		done:    make(chan struct{}),
+		lines:   newLineBuffer(),
+	}
+	go s.loop()
+	return s, nil
+}
+
+func (s *SyslogSink) Write(ctx context.Context, stream actionrunner.Stream, ts time.Time, line []byte) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.append(key, line) {
+		s.enqueue(stream, ts, l, fields)
+	}
+	return nil
+}
+
+// Flush emits whatever partial line is still buffered for stream, since the
+// container's output has closed without ever sending the trailing \n that
+// would otherwise complete it.
+func (s *SyslogSink) Flush(ctx context.Context, stream actionrunner.Stream) error {
+	fields := actionrunner.LogFieldsFromContext(ctx)
+	key := lineBufferKey(fields.ContainerHandle, stream)
+
+	for _, l := range s.lines.flush(key) {
+		s.enqueue(stream, time.Now(), l, fields)
+	}
+	return nil
+}
+
+func (s *SyslogSink) enqueue(stream actionrunner.Stream, ts time.Time, line []byte, fields actionrunner.LogFields) {
+	buf := make([]byte, len(line))
+	copy(buf, line)
+
+	select {
+	case s.queue <- syslogLine{stream: stream, ts: ts, line: buf, fields: fields}:
+	default:
+		if s.metrics != nil {
+			s.metrics.IncrementCounter(SyslogDroppedLineCount)
+		}
+	}
+}
+
+func (s *SyslogSink) Close() error {
+	close(s.done)
+	return s.conn.Close()
+}
+
+func (s *SyslogSink) loop() {
+	for {
+		select {
+		case l := <-s.queue:
+			if _, err := s.conn.Write(formatRFC5424(s.appName, l)); err != nil {
+				if s.metrics != nil {
+					s.metrics.IncrementCounter(SyslogDroppedLineCount)
+				}
+				s.redial()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// redial replaces a dead connection so a transient network error doesn't
+// permanently stop delivery. Failures here are silent by design: the next
+// write attempt will try again, dropping and counting lines in the
+// meantime rather than blocking the warden stream read loop on a retry
+// loop.
+func (s *SyslogSink) redial() {
+	conn, err := net.Dial("tcp", s.address)
+	if err != nil {
+		return
+	}
+	s.conn.Close()
+	s.conn = conn
+}
+
+var _ actionrunner.LogSink = (*SyslogSink)(nil)
+
+func formatRFC5424(appName string, l syslogLine) []byte {
+	severity := syslogSeverityInfo
+	if l.stream == actionrunner.Stderr {
+		severity = syslogSeverityErr
+	}
+	priority := syslogFacilityLocal0*8 + severity
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	structuredData := fmt.Sprintf(
+		`[diego@47450 container_handle="%s" app_guid="%s" index="%s"]`,
+		l.fields.ContainerHandle, l.fields.AppGUID, l.fields.Index,
+	)
+
+	return []byte(fmt.Sprintf(
+		"<%d>1 %s %s %s - - %s %s\n",
+		priority, l.ts.UTC().Format(time.RFC3339Nano), hostname, appName, structuredData, l.line,
+	))
+}
@@ -1,6 +1,7 @@
 package actionrunner
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -14,16 +15,18 @@ import (
 type RunRunner struct {
 	wardenClient  gordon.Client
 	backendPlugin BackendPlugin
+	logSinks      []LogSink
 }
 
-func NewRunRunner(wardenClient gordon.Client, backendPlugin BackendPlugin) *RunRunner {
+func NewRunRunner(wardenClient gordon.Client, backendPlugin BackendPlugin, logSinks ...LogSink) *RunRunner {
 	return &RunRunner{
 		wardenClient:  wardenClient,
 		backendPlugin: backendPlugin,
+		logSinks:      logSinks,
 	}
 }
 
-func (runner *RunRunner) perform(containerHandle string, emitter emitter.Emitter, action models.RunAction) error {
+func (runner *RunRunner) perform(containerHandle, appGUID, index string, emitter emitter.Emitter, action models.RunAction) error {
 	exitStatusChan := make(chan uint32, 1)
 	errChan := make(chan error, 1)
 
@@ -33,6 +36,12 @@ func (runner *RunRunner) perform(containerHandle string, emitter emitter.Emitter
 		timeoutChan = time.After(action.Timeout)
 	}
 
+	sinkCtx := WithLogFields(context.Background(), LogFields{
+		ContainerHandle: containerHandle,
+		AppGUID:         appGUID,
+		Index:           index,
+	})
+
 	go func() {
 		_, stream, err := runner.wardenClient.Run(
 			containerHandle,
@@ -50,15 +59,21 @@ func (runner *RunRunner) perform(containerHandle string, emitter emitter.Emitter
 				break
 			}
 
-			if emitter != nil {
-				switch *payload.Source {
-				case warden.ProcessPayload_stdout:
+			switch *payload.Source {
+			case warden.ProcessPayload_stdout:
+				if emitter != nil {
 					emitter.EmitStdout(payload.GetData())
-				case warden.ProcessPayload_stderr:
+				}
+				runner.writeToSinks(sinkCtx, Stdout, payload.GetData())
+			case warden.ProcessPayload_stderr:
+				if emitter != nil {
 					emitter.EmitStderr(payload.GetData())
 				}
+				runner.writeToSinks(sinkCtx, Stderr, payload.GetData())
 			}
 		}
+
+		runner.flushSinks(sinkCtx)
 	}()
 
 	select {
@@ -78,3 +93,28 @@ func (runner *RunRunner) perform(containerHandle string, emitter emitter.Emitter
 
 	panic("unreachable")
 }
+
+// writeToSinks fans a chunk of process output out to every configured
+// LogSink in addition to the in-process emitter. Sinks are expected to
+// buffer and drop rather than block, so a slow syslog server can't stall
+// this read loop.
+func (runner *RunRunner) writeToSinks(ctx context.Context, stream Stream, data []byte) {
+	if len(runner.logSinks) == 0 {
+		return
+	}
+
+	ts := time.Now()
+	for _, sink := range runner.logSinks {
+		sink.Write(ctx, stream, ts, data)
+	}
+}
+
+// flushSinks tells every configured LogSink that this container's output
+// streams have closed, so a final line that never saw a trailing newline
+// still gets emitted instead of sitting in a sink's internal buffer forever.
+func (runner *RunRunner) flushSinks(ctx context.Context) {
+	for _, sink := range runner.logSinks {
+		sink.Flush(ctx, Stdout)
+		sink.Flush(ctx, Stderr)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/tedsuo/ifrit"
+
+	"github.com/cloudfoundry-incubator/executor/depot/containerstore"
+)
+
+var watchCAReload = flag.Bool(
+	"watchCAReload",
+	false,
+	"watch -caCertFile/-caKeyFile for changes and hot-reload the intermediate CA, instead of requiring an executor restart to rotate it",
+)
+
+// CAReloadRunner returns the ifrit.Runner that keeps signer's intermediate
+// CA in sync with caCertFile/caKeyFile, or nil if -watchCAReload is false.
+// It returns an error unless signer is a *containerstore.LocalCASigner: a
+// RemoteSigner has no local CA material to reload.
+func CAReloadRunner(logger lager.Logger, signer containerstore.Signer, caCertFile, caKeyFile string) (ifrit.Runner, error) {
+	if !*watchCAReload {
+		return nil, nil
+	}
+
+	localSigner, ok := signer.(*containerstore.LocalCASigner)
+	if !ok {
+		return nil, fmt.Errorf("-watchCAReload requires -signerType=local")
+	}
+
+	return containerstore.WatchCAReload(logger, localSigner, caCertFile, caKeyFile)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+
+	"github.com/cloudfoundry-incubator/executor/depot/containerstore"
+)
+
+var signerType = flag.String(
+	"signerType",
+	"local",
+	"which Signer issues container credentials: 'local' (sign with an on-cell intermediate CA key) or 'remote' (delegate to an external CA over HTTP)",
+)
+
+var remoteSignerURL = flag.String(
+	"remoteSignerURL",
+	"",
+	"endpoint to POST CSRs to when -signerType=remote",
+)
+
+var remoteSignerToken = flag.String(
+	"remoteSignerToken",
+	"",
+	"bearer token to authenticate to -remoteSignerURL when -signerType=remote",
+)
+
+// NewSigner builds the containerstore.Signer selected by -signerType. For
+// "local" it loads the intermediate CA cert/chain and key from caCertFile
+// and caKeyFile so the returned *containerstore.LocalCASigner can later be
+// handed to CAReloadRunner. For "remote" it ignores the CA files entirely
+// and talks to -remoteSignerURL instead.
+func NewSigner(caCertFile, caKeyFile string, entropyReader io.Reader, validityPeriod time.Duration) (containerstore.Signer, error) {
+	switch *signerType {
+	case "local":
+		signer := containerstore.NewLocalCASigner(nil, nil, nil, entropyReader, clock.NewClock(), validityPeriod)
+		if err := signer.ReloadCA(caCertFile, caKeyFile); err != nil {
+			return nil, err
+		}
+		return signer, nil
+	case "remote":
+		if *remoteSignerURL == "" {
+			return nil, fmt.Errorf("-remoteSignerURL is required when -signerType=remote")
+		}
+		return containerstore.NewRemoteSigner(*remoteSignerURL, *remoteSignerToken, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown -signerType %q: must be 'local' or 'remote'", *signerType)
+	}
+}
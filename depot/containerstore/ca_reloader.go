@@ -0,0 +1,126 @@
+package containerstore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tedsuo/ifrit"
+
+	"code.cloudfoundry.org/lager"
+)
+
+func parseCertificateChain(certPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != certificatePEMBlockType {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in CA key file")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("CA key does not support signing")
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unable to parse CA private key")
+}
+
+// WatchCAReload returns an ifrit.Runner that watches the directories
+// containing certPath and keyPath and calls signer.ReloadCA on any event in
+// them, so operators can rotate the intermediate CA without restarting the
+// executor. It deliberately doesn't filter events down to certPath/keyPath
+// themselves: Kubernetes- and Vault-style secret rotation swaps a `..data`
+// symlink inside the directory rather than writing to the file's own path,
+// so the fsnotify event name for that kind of rotation is never certPath or
+// keyPath. ReloadCA re-reads both files from scratch on every call, so an
+// unrelated event in the directory costs a redundant read rather than a
+// missed reload.
+func WatchCAReload(logger lager.Logger, signer *LocalCASigner, certPath, keyPath string) (ifrit.Runner, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range uniqueDirs(certPath, keyPath) {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	return ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+		logger = logger.Session("ca-reload-watcher")
+		defer watcher.Close()
+		close(ready)
+		logger.Info("started")
+
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return nil
+				}
+				if err := signer.ReloadCA(certPath, keyPath); err != nil {
+					logger.Error("failed-to-reload-ca", err)
+					continue
+				}
+				logger.Info("reloaded-ca")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return nil
+				}
+				logger.Error("watch-error", err)
+			case <-signals:
+				return nil
+			}
+		}
+	}), nil
+}
+
+func uniqueDirs(paths ...string) []string {
+	seen := map[string]struct{}{}
+	var dirs []string
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		if _, ok := seen[dir]; ok {
+			continue
+		}
+		seen[dir] = struct{}{}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
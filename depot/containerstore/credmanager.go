@@ -2,18 +2,17 @@ package containerstore
 
 import (
 	"bytes"
-	"crypto/rsa"
+	"context"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/pem"
 	"io"
 	"math/big"
-	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
-	uuid "github.com/nu7hatch/gouuid"
 	"github.com/tedsuo/ifrit"
 
 	"code.cloudfoundry.org/clock"
@@ -27,6 +26,8 @@ const (
 	CredCreationSucceededCount    = "CredCreationSucceededCount"
 	CredCreationSucceededDuration = "CredCreationSucceededDuration"
 	CredCreationFailedCount       = "CredCreationFailedCount"
+	CredRevocationSucceededCount  = "CredRevocationSucceededCount"
+	CredRevocationFailedCount     = "CredRevocationFailedCount"
 )
 
 type Credential struct {
@@ -69,9 +70,20 @@ type credManager struct {
 	validityPeriod time.Duration
 	entropyReader  io.Reader
 	clock          clock.Clock
-	CaCert         *x509.Certificate
-	privateKey     *rsa.PrivateKey
+	signer         Signer
+	revoker        Revoker
+	defaultKeyType KeyType
 	handlers       []CredentialHandler
+
+	issuedSerialsMutex sync.Mutex
+	issuedSerials      map[string][]issuedSerial
+}
+
+// issuedSerial is one certificate issued for a container, tracked so it can
+// be revoked at teardown and pruned once it's no longer possibly trusted.
+type issuedSerial struct {
+	Serial   *big.Int
+	NotAfter time.Time
 }
 
 //go:generate counterfeiter -o containerstorefakes/fake_cred_handler.go . CredentialHandler
@@ -99,8 +111,9 @@ func NewCredManager(
 	validityPeriod time.Duration,
 	entropyReader io.Reader,
 	clock clock.Clock,
-	CaCert *x509.Certificate,
-	privateKey *rsa.PrivateKey,
+	signer Signer,
+	revoker Revoker,
+	defaultKeyType KeyType,
 	handlers ...CredentialHandler,
 ) CredManager {
 	return &credManager{
@@ -109,13 +122,25 @@ func NewCredManager(
 		validityPeriod: validityPeriod,
 		entropyReader:  entropyReader,
 		clock:          clock,
-		CaCert:         CaCert,
-		privateKey:     privateKey,
+		signer:         signer,
+		revoker:        revoker,
+		defaultKeyType: defaultKeyType,
 		handlers:       handlers,
+		issuedSerials:  map[string][]issuedSerial{},
 	}
 }
 
-func calculateCredentialRotationPeriod(validityPeriod time.Duration) time.Duration {
+// calculateCredentialRotationPeriod derives how long to wait before
+// rotating a just-issued credential. It prefers the actual notAfter
+// reported by the signer over the locally-configured validityPeriod,
+// since a remote CA is free to clamp the lifetime it grants.
+func calculateCredentialRotationPeriod(now, notAfter time.Time, validityPeriod time.Duration) time.Duration {
+	if !notAfter.IsZero() {
+		if actual := notAfter.Sub(now); actual > 0 {
+			validityPeriod = actual
+		}
+	}
+
 	if validityPeriod > 4*time.Hour {
 		return validityPeriod - 30*time.Minute
 	}
@@ -166,7 +191,7 @@ func (c *credManager) Runner(logger lager.Logger, container executor.Container)
 		defer logger.Info("finished")
 
 		start := c.clock.Now()
-		creds, err := c.generateCreds(logger, container, container.Guid)
+		creds, notAfter, err := c.generateCreds(logger, container, container.Guid)
 		duration := c.clock.Since(start)
 		if err != nil {
 			logger.Error("failed-to-generate-credentials", err)
@@ -184,7 +209,7 @@ func (c *credManager) Runner(logger lager.Logger, container executor.Container)
 		c.metronClient.IncrementCounter(CredCreationSucceededCount)
 		c.metronClient.SendDuration(CredCreationSucceededDuration, duration)
 
-		rotationDuration := calculateCredentialRotationPeriod(c.validityPeriod)
+		rotationDuration := calculateCredentialRotationPeriod(start, notAfter, c.validityPeriod)
 		regenCertTimer := c.clock.NewTimer(rotationDuration)
 
 		close(ready)
@@ -196,7 +221,7 @@ func (c *credManager) Runner(logger lager.Logger, container executor.Container)
 			case <-regenCertTimer.C():
 				regenLogger.Debug("started")
 				start := c.clock.Now()
-				creds, err := c.generateCreds(logger, container, container.Guid)
+				creds, notAfter, err := c.generateCreds(logger, container, container.Guid)
 				duration := c.clock.Since(start)
 				if err != nil {
 					regenLogger.Error("failed-to-generate-credentials", err)
@@ -213,11 +238,13 @@ func (c *credManager) Runner(logger lager.Logger, container executor.Container)
 					}
 				}
 
-				rotationDuration = calculateCredentialRotationPeriod(c.validityPeriod)
+				rotationDuration = calculateCredentialRotationPeriod(start, notAfter, c.validityPeriod)
 				regenCertTimer.Reset(rotationDuration)
 				regenLogger.Debug("completed")
 			case signal := <-signals:
-				cred, err := c.generateCreds(logger, container, "")
+				c.revokeIssuedCreds(logger, container.Guid)
+
+				cred, _, err := c.generateCreds(logger, container, "")
 				if err != nil {
 					regenLogger.Error("failed-to-generate-credentials", err)
 					c.metronClient.IncrementCounter(CredCreationFailedCount)
@@ -237,18 +264,23 @@ func (c *credManager) Runner(logger lager.Logger, container executor.Container)
 
 const (
 	certificatePEMBlockType = "CERTIFICATE"
-	privateKeyPEMBlockType  = "RSA PRIVATE KEY"
+	privateKeyPEMBlockType  = "PRIVATE KEY"
 )
 
-func (c *credManager) generateCreds(logger lager.Logger, container executor.Container, certGUID string) (Credential, error) {
+func (c *credManager) generateCreds(logger lager.Logger, container executor.Container, certGUID string) (Credential, time.Time, error) {
 	logger = logger.Session("generating-credentials")
 	logger.Info("starting")
 	defer logger.Info("complete")
 
+	keyType := container.CertificateProperties.KeyType
+	if keyType == "" {
+		keyType = c.defaultKeyType
+	}
+
 	logger.Debug("generating-private-key")
-	privateKey, err := rsa.GenerateKey(c.entropyReader, 2048)
+	privateKey, err := generateKeyPair(c.entropyReader, keyType)
 	if err != nil {
-		return Credential{}, err
+		return Credential{}, time.Time{}, err
 	}
 	logger.Debug("generated-private-key")
 
@@ -257,58 +289,62 @@ func (c *credManager) generateCreds(logger lager.Logger, container executor.Cont
 		ipForCert = container.ExternalIP
 	}
 
-	startValidity := c.clock.Now()
+	csrTemplate := createCertificateSigningRequestTemplate(ipForCert, certGUID, container.CertificateProperties.OrganizationalUnit)
 
-	template := createCertificateTemplate(ipForCert,
-		certGUID,
-		startValidity,
-		startValidity.Add(c.validityPeriod),
-		container.CertificateProperties.OrganizationalUnit,
-	)
-
-	logger.Debug("generating-serial-number")
-	guid, err := uuid.NewV4()
+	logger.Debug("generating-certificate-signing-request")
+	csrDER, err := x509.CreateCertificateRequest(c.entropyReader, csrTemplate, privateKey)
 	if err != nil {
-		logger.Error("failed-to-generate-uuid", err)
-		return Credential{}, err
+		return Credential{}, time.Time{}, err
 	}
-	logger.Debug("generated-serial-number")
+	logger.Debug("generated-certificate-signing-request")
 
-	guidBytes := [16]byte(*guid)
-	template.SerialNumber.SetBytes(guidBytes[:])
+	profile := strings.Join(container.CertificateProperties.OrganizationalUnit, "/")
 
-	logger.Debug("generating-certificate")
-	certBytes, err := x509.CreateCertificate(c.entropyReader, template, c.CaCert, privateKey.Public(), c.privateKey)
+	logger.Debug("signing-certificate")
+	certDER, chainDER, notAfter, err := c.signer.SignCSR(context.Background(), csrDER, profile)
 	if err != nil {
-		return Credential{}, err
+		return Credential{}, time.Time{}, err
+	}
+	logger.Debug("signed-certificate")
+
+	if certGUID != "" {
+		cert, err := x509.ParseCertificate(certDER)
+		if err != nil {
+			return Credential{}, time.Time{}, err
+		}
+		c.recordIssuedSerial(container.Guid, cert.SerialNumber, cert.NotAfter)
 	}
-	logger.Debug("generated-certificate")
 
-	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privateKey)
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return Credential{}, time.Time{}, err
+	}
 
 	var keyBuf bytes.Buffer
 	err = pemEncode(privateKeyBytes, privateKeyPEMBlockType, &keyBuf)
 	if err != nil {
-		return Credential{}, err
+		return Credential{}, time.Time{}, err
 	}
 
 	var certificateBuf bytes.Buffer
 	certificateWriter := &certificateBuf
-	err = pemEncode(certBytes, certificatePEMBlockType, certificateWriter)
+	err = pemEncode(certDER, certificatePEMBlockType, certificateWriter)
 	if err != nil {
-		return Credential{}, err
+		return Credential{}, time.Time{}, err
 	}
 
-	err = pemEncode(c.CaCert.Raw, certificatePEMBlockType, certificateWriter)
-	if err != nil {
-		return Credential{}, err
+	for _, chainCertDER := range chainDER {
+		err = pemEncode(chainCertDER, certificatePEMBlockType, certificateWriter)
+		if err != nil {
+			return Credential{}, time.Time{}, err
+		}
 	}
 
 	creds := Credential{
 		Cert: certificateBuf.String(),
 		Key:  keyBuf.String(),
 	}
-	return creds, nil
+	return creds, notAfter, nil
 }
 
 func pemEncode(bytes []byte, blockType string, writer io.Writer) error {
@@ -319,24 +355,45 @@ func pemEncode(bytes []byte, blockType string, writer io.Writer) error {
 	return pem.Encode(writer, block)
 }
 
-func createCertificateTemplate(ipaddress, guid string, notBefore, notAfter time.Time, organizationalUnits []string) *x509.Certificate {
-	var ipaddr []net.IP
-	if len(ipaddress) == 0 {
-		ipaddr = []net.IP{}
-	} else {
-		ipaddr = []net.IP{net.ParseIP(ipaddress)}
+// recordIssuedSerial adds serial to the set of certificates tracked for
+// containerGuid and prunes any previously recorded serial whose NotAfter
+// has already passed. A rotation does not revoke the cert it supersedes
+// (it's still valid until its own NotAfter, and calculateCredentialRotationPeriod
+// rotates well before then), so every unexpired serial must be kept around
+// for revokeIssuedCreds to revoke at teardown; only serials that have
+// naturally expired, and so can no longer be trusted by anyone, are safe to
+// drop here.
+func (c *credManager) recordIssuedSerial(containerGuid string, serial *big.Int, notAfter time.Time) {
+	c.issuedSerialsMutex.Lock()
+	defer c.issuedSerialsMutex.Unlock()
+
+	now := c.clock.Now()
+	live := []issuedSerial{{Serial: serial, NotAfter: notAfter}}
+	for _, s := range c.issuedSerials[containerGuid] {
+		if s.NotAfter.After(now) {
+			live = append(live, s)
+		}
 	}
-	return &x509.Certificate{
-		SerialNumber: big.NewInt(0),
-		Subject: pkix.Name{
-			CommonName:         guid,
-			OrganizationalUnit: organizationalUnits,
-		},
-		IPAddresses: ipaddr,
-		DNSNames:    []string{guid},
-		NotBefore:   notBefore,
-		NotAfter:    notAfter,
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	c.issuedSerials[containerGuid] = live
+}
+
+// revokeIssuedCreds revokes every still-unexpired certificate issued for
+// the given container so that anything still caching one (an mTLS peer, an
+// OCSP stapling validator, a downstream proxy) stops trusting it instead of
+// waiting out its NotAfter.
+func (c *credManager) revokeIssuedCreds(logger lager.Logger, containerGuid string) {
+	c.issuedSerialsMutex.Lock()
+	serials := c.issuedSerials[containerGuid]
+	delete(c.issuedSerials, containerGuid)
+	c.issuedSerialsMutex.Unlock()
+
+	for _, s := range serials {
+		err := c.revoker.Revoke(s.Serial, CRLReasonCessationOfOperation)
+		if err != nil {
+			logger.Error("failed-to-revoke-certificate", err, lager.Data{"serial": s.Serial.String()})
+			c.metronClient.IncrementCounter(CredRevocationFailedCount)
+			continue
+		}
+		c.metronClient.IncrementCounter(CredRevocationSucceededCount)
 	}
 }
@@ -0,0 +1,40 @@
+package containerstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"io"
+)
+
+// KeyType selects the private key algorithm generateCreds uses for a
+// container's leaf credential. RSA-2048 keygen dominates the rotation hot
+// path on cells running hundreds of containers, so operators can opt
+// containers into the much cheaper ECDSA/Ed25519 algorithms instead.
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa-2048"
+	KeyTypeRSA4096   KeyType = "rsa-4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+func generateKeyPair(entropyReader io.Reader, keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case "", KeyTypeRSA2048:
+		return rsa.GenerateKey(entropyReader, 2048)
+	case KeyTypeRSA4096:
+		return rsa.GenerateKey(entropyReader, 4096)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), entropyReader)
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(entropyReader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported key type: %q", keyType)
+	}
+}
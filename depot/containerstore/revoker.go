@@ -0,0 +1,89 @@
+package containerstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+)
+
+// CRL reason codes, as defined in RFC 5280 section 5.3.1. Only these two are
+// defined here; credManager revokes with CRLReasonCessationOfOperation.
+const (
+	CRLReasonUnspecified          = 0
+	CRLReasonCessationOfOperation = 5
+)
+
+// Revoker tells an external CA or revocation authority that a previously
+// issued certificate must no longer be trusted.
+//
+//go:generate counterfeiter -o containerstorefakes/fake_revoker.go . Revoker
+type Revoker interface {
+	Revoke(serial *big.Int, reason int) error
+}
+
+// NoopRevoker is used when no revocation endpoint is configured; it leaves
+// previously issued certificates to expire naturally at their NotAfter.
+type NoopRevoker struct{}
+
+func NewNoopRevoker() *NoopRevoker {
+	return &NoopRevoker{}
+}
+
+func (r *NoopRevoker) Revoke(serial *big.Int, reason int) error {
+	return nil
+}
+
+// HTTPRevoker publishes revocations to an upstream CRL/OCSP responder by
+// POSTing the serial and reason code.
+type HTTPRevoker struct {
+	Endpoint   string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func NewHTTPRevoker(endpoint, token string, httpClient *http.Client) *HTTPRevoker {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &HTTPRevoker{
+		Endpoint:   endpoint,
+		Token:      token,
+		HTTPClient: httpClient,
+	}
+}
+
+type revokeRequest struct {
+	Serial string `json:"serial"`
+	Reason int    `json:"reason"`
+}
+
+func (r *HTTPRevoker) Revoke(serial *big.Int, reason int) error {
+	body, err := json.Marshal(revokeRequest{
+		Serial: serial.String(),
+		Reason: reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.Token)
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("revocation endpoint returned unexpected status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
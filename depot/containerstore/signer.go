@@ -0,0 +1,248 @@
+package containerstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+
+	"code.cloudfoundry.org/clock"
+)
+
+// Signer signs a certificate signing request and returns the leaf
+// certificate, the issuing chain (each entry DER-encoded, ordered from the
+// issuer of the leaf up to, but not necessarily including, the trust
+// anchor), and the NotAfter of the issued leaf. Implementations are free to
+// clamp the requested validity period, which is why callers must use the
+// returned notAfter rather than assuming the locally-configured one.
+//
+//go:generate counterfeiter -o containerstorefakes/fake_signer.go . Signer
+type Signer interface {
+	SignCSR(ctx context.Context, csrDER []byte, profile string) (certDER []byte, chainDER [][]byte, notAfter time.Time, err error)
+}
+
+func createCertificateSigningRequestTemplate(ipaddress, guid string, organizationalUnits []string) *x509.CertificateRequest {
+	var ipaddr []net.IP
+	if len(ipaddress) != 0 {
+		ipaddr = []net.IP{net.ParseIP(ipaddress)}
+	}
+	return &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         guid,
+			OrganizationalUnit: organizationalUnits,
+		},
+		IPAddresses: ipaddr,
+		DNSNames:    []string{guid},
+	}
+}
+
+// caMaterial is the intermediate CA's identity: the cert used to sign
+// leaves, the chain from that cert up to (but not including) the trust
+// anchor, and the signing key. It is swapped out as a unit by ReloadCA so
+// that no in-flight SignCSR call ever sees a cert paired with the wrong
+// key.
+type caMaterial struct {
+	Cert  *x509.Certificate
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// LocalCASigner signs CSRs in-process using an online intermediate CA
+// key. It is the signer credManager used exclusively before the Signer
+// abstraction existed, and remains the default for operators who are fine
+// holding the intermediate key on the cell. The CA material is held behind
+// an atomic pointer so ReloadCA can rotate the intermediate without
+// restarting the executor.
+type LocalCASigner struct {
+	EntropyReader  io.Reader
+	Clock          clock.Clock
+	ValidityPeriod time.Duration
+
+	ca atomic.Pointer[caMaterial]
+}
+
+func NewLocalCASigner(caCert *x509.Certificate, chain []*x509.Certificate, key crypto.Signer, entropyReader io.Reader, clock clock.Clock, validityPeriod time.Duration) *LocalCASigner {
+	s := &LocalCASigner{
+		EntropyReader:  entropyReader,
+		Clock:          clock,
+		ValidityPeriod: validityPeriod,
+	}
+	s.ca.Store(&caMaterial{Cert: caCert, Chain: chain, Key: key})
+	return s
+}
+
+// ReloadCA re-reads the CA certificate (and any intermediates stacked in
+// the same PEM file) and key from disk and atomically swaps them in.
+// Leaves issued before the reload stay valid under the old intermediate
+// until their own NotAfter; leaves issued after chain up under the new one.
+func (s *LocalCASigner) ReloadCA(certPath, keyPath string) error {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+
+	certs, err := parseCertificateChain(certPEM)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates found in %s", certPath)
+	}
+
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	s.ca.Store(&caMaterial{Cert: certs[0], Chain: certs[1:], Key: key})
+	return nil
+}
+
+func (s *LocalCASigner) SignCSR(ctx context.Context, csrDER []byte, profile string) ([]byte, [][]byte, time.Time, error) {
+	// Snapshot the CA material once so a concurrent ReloadCA can't hand us
+	// a cert signed by one key and a different key to sign with.
+	material := s.ca.Load()
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	notBefore := s.Clock.Now()
+	notAfter := notBefore.Add(s.ValidityPeriod)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0),
+		Subject:      csr.Subject,
+		IPAddresses:  csr.IPAddresses,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	guid, err := uuid.NewV4()
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	guidBytes := [16]byte(*guid)
+	template.SerialNumber.SetBytes(guidBytes[:])
+
+	// template.SignatureAlgorithm is intentionally left unset: x509.CreateCertificate
+	// picks the algorithm from the signing key (material.Key), so the leaf's own key
+	// type never has to match it.
+	certDER, err := x509.CreateCertificate(s.EntropyReader, template, material.Cert, csr.PublicKey, material.Key)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	chainDER := make([][]byte, 0, len(material.Chain)+1)
+	chainDER = append(chainDER, material.Cert.Raw)
+	for _, chainCert := range material.Chain {
+		chainDER = append(chainDER, chainCert.Raw)
+	}
+
+	return certDER, chainDER, notAfter, nil
+}
+
+// RemoteSigner sends the CSR to an external CA over HTTP instead of signing
+// with a key held on the cell. This keeps the intermediate CA key off of
+// every executor at the cost of a network round trip per issuance.
+type RemoteSigner struct {
+	Endpoint   string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func NewRemoteSigner(endpoint, token string, httpClient *http.Client) *RemoteSigner {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &RemoteSigner{
+		Endpoint:   endpoint,
+		Token:      token,
+		HTTPClient: httpClient,
+	}
+}
+
+type remoteSignRequest struct {
+	CSR     string `json:"csr"`
+	Profile string `json:"profile"`
+}
+
+type remoteSignResponse struct {
+	Cert     string    `json:"cert"`
+	Chain    []string  `json:"chain"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+func (s *RemoteSigner) SignCSR(ctx context.Context, csrDER []byte, profile string) ([]byte, [][]byte, time.Time, error) {
+	reqBody, err := json.Marshal(remoteSignRequest{
+		CSR:     base64.StdEncoding.EncodeToString(csrDER),
+		Profile: profile,
+	})
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, time.Time{}, fmt.Errorf("remote signer returned unexpected status: %d", resp.StatusCode)
+	}
+
+	var signResp remoteSignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signResp); err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	certDER, err := base64.StdEncoding.DecodeString(signResp.Cert)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	chainDER := make([][]byte, 0, len(signResp.Chain))
+	for _, encoded := range signResp.Chain {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		chainDER = append(chainDER, der)
+	}
+
+	return certDER, chainDER, signResp.NotAfter, nil
+}